@@ -0,0 +1,25 @@
+// Copyright 2019 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftpb
+
+// AsEntry marshals the ConfChange into the Data of an Entry of type
+// EntryConfChange at the given term and index.
+func (c ConfChange) AsEntry(term, index uint64) (Entry, error) {
+	data, err := c.Marshal()
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{Type: EntryConfChange, Term: term, Index: index, Data: data}, nil
+}
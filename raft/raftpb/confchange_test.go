@@ -0,0 +1,45 @@
+// Copyright 2019 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftpb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfChangeAsEntry(t *testing.T) {
+	tests := []ConfChange{
+		{Type: ConfChangeAddNode, NodeID: 1},
+		{ID: 3, Type: ConfChangeRemoveNode, NodeID: 2},
+		{Type: ConfChangeAddLearnerNode, NodeID: 4, Context: []byte("ctx")},
+	}
+
+	for i, cc := range tests {
+		e, err := cc.AsEntry(2, 7)
+		if err != nil {
+			t.Fatalf("#%d: unexpected error: %v", i, err)
+		}
+		if e.Type != EntryConfChange || e.Term != 2 || e.Index != 7 {
+			t.Errorf("#%d: entry = %d/%d %s, want 2/7 %s", i, e.Term, e.Index, e.Type, EntryConfChange)
+		}
+		var g ConfChange
+		if err := g.Unmarshal(e.Data); err != nil {
+			t.Fatalf("#%d: unexpected error: %v", i, err)
+		}
+		if !reflect.DeepEqual(g, cc) {
+			t.Errorf("#%d: conf change = %+v, want %+v", i, g, cc)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftpb
+
+import "sort"
+
+// MakeConfState returns a ConfState with the given voters and learners,
+// each sorted in ascending order and with duplicates removed. The input
+// slices are not modified.
+func MakeConfState(voters, learners []uint64) ConfState {
+	return ConfState{Nodes: normalizeIDs(voters), Learners: normalizeIDs(learners)}
+}
+
+// AllIDs returns the IDs of all voters and learners in c, sorted in
+// ascending order and with duplicates removed.
+func (c ConfState) AllIDs() []uint64 {
+	ids := make([]uint64, 0, len(c.Nodes)+len(c.Learners))
+	ids = append(append(ids, c.Nodes...), c.Learners...)
+	return normalizeIDs(ids)
+}
+
+// MergeConfState returns the union of a and b, for reconciling two
+// diverged configurations during disaster recovery. A node that is a voter
+// in one input and a learner in the other becomes a voter. The result is
+// normalized as by MakeConfState.
+func MergeConfState(a, b ConfState) ConfState {
+	var voters, learners []uint64
+	voters = append(append(voters, a.Nodes...), b.Nodes...)
+	learners = append(append(learners, a.Learners...), b.Learners...)
+	cs := MakeConfState(voters, learners)
+
+	isVoter := make(map[uint64]bool, len(cs.Nodes))
+	for _, id := range cs.Nodes {
+		isVoter[id] = true
+	}
+	n := 0
+	for _, id := range cs.Learners {
+		if !isVoter[id] {
+			cs.Learners[n] = id
+			n++
+		}
+	}
+	if n == 0 {
+		cs.Learners = nil
+	} else {
+		cs.Learners = cs.Learners[:n]
+	}
+	return cs
+}
+
+// Clone returns a deep copy of c, so that modifying the slices of one does
+// not affect the other.
+func (c ConfState) Clone() ConfState {
+	var cs ConfState
+	if c.Nodes != nil {
+		cs.Nodes = append([]uint64{}, c.Nodes...)
+	}
+	if c.Learners != nil {
+		cs.Learners = append([]uint64{}, c.Learners...)
+	}
+	return cs
+}
+
+// VotersEqual reports whether c and other have the same set of voters,
+// regardless of order and duplicates. Learners are ignored.
+func (c ConfState) VotersEqual(other ConfState) bool {
+	a, b := normalizeIDs(c.Nodes), normalizeIDs(other.Nodes)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeIDs returns a sorted, deduplicated copy of ids, or nil if ids
+// is empty.
+func normalizeIDs(ids []uint64) []uint64 {
+	if len(ids) == 0 {
+		return nil
+	}
+	sl := append([]uint64(nil), ids...)
+	sort.Slice(sl, func(i, j int) bool { return sl[i] < sl[j] })
+	n := 1
+	for _, id := range sl[1:] {
+		if id != sl[n-1] {
+			sl[n] = id
+			n++
+		}
+	}
+	return sl[:n]
+}
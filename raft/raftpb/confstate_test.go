@@ -0,0 +1,165 @@
+// Copyright 2019 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftpb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMakeConfState(t *testing.T) {
+	tests := []struct {
+		voters, learners []uint64
+
+		wcs ConfState
+	}{
+		{nil, nil, ConfState{}},
+		{[]uint64{1, 2, 3}, nil, ConfState{Nodes: []uint64{1, 2, 3}}},
+		{[]uint64{3, 1, 2}, []uint64{5, 4}, ConfState{Nodes: []uint64{1, 2, 3}, Learners: []uint64{4, 5}}},
+		// duplicates are removed
+		{[]uint64{2, 1, 2, 1, 3, 3}, []uint64{4, 4}, ConfState{Nodes: []uint64{1, 2, 3}, Learners: []uint64{4}}},
+	}
+
+	for i, tt := range tests {
+		in := append([]uint64(nil), tt.voters...)
+		cs := MakeConfState(tt.voters, tt.learners)
+		if !reflect.DeepEqual(cs, tt.wcs) {
+			t.Errorf("#%d: conf state = %+v, want %+v", i, cs, tt.wcs)
+		}
+		if !reflect.DeepEqual(tt.voters, in) {
+			t.Errorf("#%d: input modified to %v, want %v", i, tt.voters, in)
+		}
+	}
+}
+
+func TestConfStateAllIDs(t *testing.T) {
+	tests := []struct {
+		cs ConfState
+
+		wids []uint64
+	}{
+		{ConfState{}, nil},
+		{ConfState{Nodes: []uint64{3, 1, 2}}, []uint64{1, 2, 3}},
+		{ConfState{Learners: []uint64{5, 4}}, []uint64{4, 5}},
+		{ConfState{Nodes: []uint64{3, 1}, Learners: []uint64{4, 2}}, []uint64{1, 2, 3, 4}},
+		// overlapping voters and learners are reported once
+		{ConfState{Nodes: []uint64{1, 2, 2, 3}, Learners: []uint64{3, 4, 1}}, []uint64{1, 2, 3, 4}},
+	}
+
+	for i, tt := range tests {
+		if ids := tt.cs.AllIDs(); !reflect.DeepEqual(ids, tt.wids) {
+			t.Errorf("#%d: ids = %v, want %v", i, ids, tt.wids)
+		}
+	}
+}
+
+func TestMergeConfState(t *testing.T) {
+	tests := []struct {
+		a, b ConfState
+
+		wcs ConfState
+	}{
+		{ConfState{}, ConfState{}, ConfState{}},
+		// disjoint
+		{
+			ConfState{Nodes: []uint64{1, 2}, Learners: []uint64{5}},
+			ConfState{Nodes: []uint64{3, 4}, Learners: []uint64{6}},
+			ConfState{Nodes: []uint64{1, 2, 3, 4}, Learners: []uint64{5, 6}},
+		},
+		// overlapping
+		{
+			ConfState{Nodes: []uint64{1, 2, 3}, Learners: []uint64{5}},
+			ConfState{Nodes: []uint64{3, 2, 4}, Learners: []uint64{5}},
+			ConfState{Nodes: []uint64{1, 2, 3, 4}, Learners: []uint64{5}},
+		},
+		// conflicting, the voter wins
+		{
+			ConfState{Nodes: []uint64{1, 2, 3}, Learners: []uint64{4}},
+			ConfState{Nodes: []uint64{1, 2, 4}, Learners: []uint64{3, 5}},
+			ConfState{Nodes: []uint64{1, 2, 3, 4}, Learners: []uint64{5}},
+		},
+		{
+			ConfState{Nodes: []uint64{1}},
+			ConfState{Learners: []uint64{1}},
+			ConfState{Nodes: []uint64{1}},
+		},
+	}
+
+	for i, tt := range tests {
+		if cs := MergeConfState(tt.a, tt.b); !reflect.DeepEqual(cs, tt.wcs) {
+			t.Errorf("#%d: conf state = %+v, want %+v", i, cs, tt.wcs)
+		}
+		if cs := MergeConfState(tt.b, tt.a); !reflect.DeepEqual(cs, tt.wcs) {
+			t.Errorf("#%d: reversed conf state = %+v, want %+v", i, cs, tt.wcs)
+		}
+	}
+}
+
+func TestConfStateClone(t *testing.T) {
+	tests := []ConfState{
+		{},
+		{Nodes: []uint64{1, 2, 3}},
+		{Nodes: []uint64{1, 2, 3}, Learners: []uint64{4, 5}},
+	}
+
+	for i, cs := range tests {
+		orig := ConfState{
+			Nodes:    append([]uint64(nil), cs.Nodes...),
+			Learners: append([]uint64(nil), cs.Learners...),
+		}
+		clone := cs.Clone()
+		if !reflect.DeepEqual(clone, cs) {
+			t.Errorf("#%d: clone = %+v, want %+v", i, clone, cs)
+		}
+		for j := range clone.Nodes {
+			clone.Nodes[j] = 100
+		}
+		for j := range clone.Learners {
+			clone.Learners[j] = 100
+		}
+		if !reflect.DeepEqual(cs, orig) {
+			t.Errorf("#%d: original = %+v, want %+v", i, cs, orig)
+		}
+	}
+}
+
+func TestConfStateVotersEqual(t *testing.T) {
+	tests := []struct {
+		a, b ConfState
+
+		w bool
+	}{
+		{ConfState{}, ConfState{}, true},
+		{ConfState{Nodes: []uint64{1, 2, 3}}, ConfState{Nodes: []uint64{1, 2, 3}}, true},
+		// order and duplicates don't matter
+		{ConfState{Nodes: []uint64{3, 1, 2}}, ConfState{Nodes: []uint64{1, 2, 2, 3}}, true},
+		// learners differ, voters match
+		{ConfState{Nodes: []uint64{1, 2}, Learners: []uint64{3}}, ConfState{Nodes: []uint64{1, 2}, Learners: []uint64{4, 5}}, true},
+		{ConfState{Nodes: []uint64{1, 2}, Learners: []uint64{3}}, ConfState{Nodes: []uint64{1, 2}}, true},
+		// voters differ, learners match
+		{ConfState{Nodes: []uint64{1, 2}, Learners: []uint64{3}}, ConfState{Nodes: []uint64{1, 4}, Learners: []uint64{3}}, false},
+		{ConfState{Nodes: []uint64{1, 2}, Learners: []uint64{3}}, ConfState{Nodes: []uint64{1, 2, 3}, Learners: []uint64{3}}, false},
+		{ConfState{Nodes: []uint64{1}}, ConfState{}, false},
+	}
+
+	for i, tt := range tests {
+		if g := tt.a.VotersEqual(tt.b); g != tt.w {
+			t.Errorf("#%d: voters equal = %t, want %t", i, g, tt.w)
+		}
+		if g := tt.b.VotersEqual(tt.a); g != tt.w {
+			t.Errorf("#%d: reversed voters equal = %t, want %t", i, g, tt.w)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2019 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftpb
+
+import "fmt"
+
+// Validate returns an error if the Snapshot is obviously malformed, that
+// is, if it carries data but its metadata has a zero index, or if a node
+// appears both as a voter and as a learner in its ConfState.
+func (s Snapshot) Validate() error {
+	if s.Metadata.Index == 0 && len(s.Data) != 0 {
+		return fmt.Errorf("snapshot has %d bytes of data but a zero index", len(s.Data))
+	}
+	cs := s.Metadata.ConfState
+	voters := make(map[uint64]bool, len(cs.Nodes))
+	for _, id := range cs.Nodes {
+		voters[id] = true
+	}
+	for _, id := range cs.Learners {
+		if voters[id] {
+			return fmt.Errorf("node %x is in both learner and peer list", id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftpb
+
+import "testing"
+
+func TestSnapshotValidate(t *testing.T) {
+	tests := []struct {
+		snap Snapshot
+
+		werr string
+	}{
+		{Snapshot{}, ""},
+		{Snapshot{Metadata: SnapshotMetadata{Index: 4, Term: 2}}, ""},
+		{Snapshot{Data: []byte("data"), Metadata: SnapshotMetadata{Index: 4, Term: 2}}, ""},
+		{Snapshot{Data: []byte("data")}, "snapshot has 4 bytes of data but a zero index"},
+		{
+			Snapshot{Metadata: SnapshotMetadata{Index: 4, Term: 2, ConfState: ConfState{Nodes: []uint64{1, 2}, Learners: []uint64{3}}}},
+			"",
+		},
+		{
+			Snapshot{Metadata: SnapshotMetadata{Index: 4, Term: 2, ConfState: ConfState{Nodes: []uint64{1, 2}, Learners: []uint64{3, 2}}}},
+			"node 2 is in both learner and peer list",
+		},
+	}
+
+	for i, tt := range tests {
+		var g string
+		if err := tt.snap.Validate(); err != nil {
+			g = err.Error()
+		}
+		if g != tt.werr {
+			t.Errorf("#%d: err = %q, want %q", i, g, tt.werr)
+		}
+	}
+}
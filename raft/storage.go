@@ -15,7 +15,9 @@
 package raft
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"sync"
 
 	pb "go.etcd.io/etcd/raft/raftpb"
@@ -33,6 +35,10 @@ var ErrSnapOutOfDate = errors.New("requested index is older than the existing sn
 // are unavailable.
 var ErrUnavailable = errors.New("requested entry at index is unavailable")
 
+// ErrTruncateCommitted is returned by MemoryStorage.TruncateSuffix when the
+// truncation would discard entries at or below the committed index.
+var ErrTruncateCommitted = errors.New("requested index would discard committed entries")
+
 // ErrSnapshotTemporarilyUnavailable is returned by the Storage interface when the required
 // snapshot is temporarily unavailable.
 var ErrSnapshotTemporarilyUnavailable = errors.New("snapshot is temporarily unavailable")
@@ -69,6 +75,112 @@ type Storage interface {
 	Snapshot() (pb.Snapshot, error)
 }
 
+// NewMaxSizeEnforcingStorage wraps a Storage whose Entries implementation
+// ignores its maxSize argument. The returned Storage trims the entries it
+// gets from s so that their total size does not exceed maxSize, always
+// keeping at least one entry.
+func NewMaxSizeEnforcingStorage(s Storage) Storage {
+	return &maxSizeEnforcingStorage{Storage: s}
+}
+
+type maxSizeEnforcingStorage struct {
+	Storage
+}
+
+// Entries implements the Storage interface.
+func (s *maxSizeEnforcingStorage) Entries(lo, hi, maxSize uint64) ([]pb.Entry, error) {
+	ents, err := s.Storage.Entries(lo, hi, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return limitSize(ents, maxSize), nil
+}
+
+// SnapshotSized implements the SizedSnapshotStorage interface by
+// forwarding to the wrapped Storage, so that wrapping does not hide it.
+func (s *maxSizeEnforcingStorage) SnapshotSized(maxBytes uint64) (pb.Snapshot, bool, error) {
+	return SnapshotSized(s.Storage, maxBytes)
+}
+
+// SnapshotSize implements the SnapshotSizeStorage interface by forwarding
+// to the wrapped Storage, so that wrapping does not hide it.
+func (s *maxSizeEnforcingStorage) SnapshotSize() (uint64, error) {
+	return SnapshotSize(s.Storage)
+}
+
+// SizedSnapshotStorage is an optional interface a Storage may implement to
+// take the caller's preferred snapshot size into account.
+type SizedSnapshotStorage interface {
+	// SnapshotSized returns the most recent snapshot with its data limited
+	// to maxBytes. The returned bool is true if the data is complete and
+	// false if it was truncated. The interface offers no way to retrieve
+	// the remainder of truncated data; callers that need all of it must
+	// use Snapshot instead.
+	SnapshotSized(maxBytes uint64) (pb.Snapshot, bool, error)
+}
+
+// SnapshotSized returns the most recent snapshot held by s, limited to
+// maxBytes of data if s implements SizedSnapshotStorage. Otherwise the full
+// snapshot is returned and reported as complete.
+func SnapshotSized(s Storage, maxBytes uint64) (pb.Snapshot, bool, error) {
+	if ss, ok := s.(SizedSnapshotStorage); ok {
+		return ss.SnapshotSized(maxBytes)
+	}
+	snap, err := s.Snapshot()
+	if err != nil {
+		return pb.Snapshot{}, false, err
+	}
+	return snap, true, nil
+}
+
+// SnapshotSizeStorage is an optional interface a Storage may implement to
+// report the size of its snapshot data without materializing the snapshot.
+type SnapshotSizeStorage interface {
+	// SnapshotSize returns the size in bytes of the data of the most recent
+	// snapshot.
+	SnapshotSize() (uint64, error)
+}
+
+// SnapshotSize returns the size in bytes of the data of the most recent
+// snapshot held by s. If s implements SnapshotSizeStorage it is asked
+// directly, otherwise the snapshot is retrieved and measured.
+func SnapshotSize(s Storage) (uint64, error) {
+	if ss, ok := s.(SnapshotSizeStorage); ok {
+		return ss.SnapshotSize()
+	}
+	snap, err := s.Snapshot()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(snap.Data)), nil
+}
+
+// AppliedTracker records the highest applied index reported by the
+// application, so that snapshot-trigger logic can consult it alongside the
+// Storage. The zero value is ready to use. It is safe for concurrent use.
+type AppliedTracker struct {
+	mu      sync.Mutex
+	applied uint64
+}
+
+// SetApplied records that the application has applied all entries up to
+// and including index. Indexes lower than the one already recorded are
+// ignored.
+func (t *AppliedTracker) SetApplied(index uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if index > t.applied {
+		t.applied = index
+	}
+}
+
+// Applied returns the highest applied index recorded so far.
+func (t *AppliedTracker) Applied() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.applied
+}
+
 // MemoryStorage implements the Storage interface backed by an
 // in-memory array.
 type MemoryStorage struct {
@@ -91,6 +203,17 @@ func NewMemoryStorage() *MemoryStorage {
 	}
 }
 
+// Reset returns the storage to the state of a newly created MemoryStorage,
+// discarding all entries, the snapshot and the hard state. It must not be
+// called while the storage is in use by a raft instance.
+func (ms *MemoryStorage) Reset() {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.hardState = pb.HardState{}
+	ms.snapshot = pb.Snapshot{}
+	ms.ents = make([]pb.Entry, 1)
+}
+
 // InitialState implements the Storage interface.
 func (ms *MemoryStorage) InitialState() (pb.HardState, pb.ConfState, error) {
 	return ms.hardState, ms.snapshot.Metadata.ConfState, nil
@@ -124,6 +247,21 @@ func (ms *MemoryStorage) Entries(lo, hi, maxSize uint64) ([]pb.Entry, error) {
 	return limitSize(ents, maxSize), nil
 }
 
+// Entry returns the log entry at index i. It returns ErrCompacted if i
+// precedes FirstIndex() and ErrUnavailable if i is beyond LastIndex().
+func (ms *MemoryStorage) Entry(i uint64) (pb.Entry, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	offset := ms.ents[0].Index
+	if i <= offset {
+		return pb.Entry{}, ErrCompacted
+	}
+	if i > ms.lastIndex() {
+		return pb.Entry{}, ErrUnavailable
+	}
+	return ms.ents[i-offset], nil
+}
+
 // Term implements the Storage interface.
 func (ms *MemoryStorage) Term(i uint64) (uint64, error) {
 	ms.Lock()
@@ -138,6 +276,20 @@ func (ms *MemoryStorage) Term(i uint64) (uint64, error) {
 	return ms.ents[i-offset].Term, nil
 }
 
+// ConfChangeIndexes returns the indexes of all EntryConfChange entries
+// currently held in the log, in ascending order.
+func (ms *MemoryStorage) ConfChangeIndexes() ([]uint64, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	var idxs []uint64
+	for _, e := range ms.ents[1:] {
+		if e.Type == pb.EntryConfChange {
+			idxs = append(idxs, e.Index)
+		}
+	}
+	return idxs, nil
+}
+
 // LastIndex implements the Storage interface.
 func (ms *MemoryStorage) LastIndex() (uint64, error) {
 	ms.Lock()
@@ -149,6 +301,15 @@ func (ms *MemoryStorage) lastIndex() uint64 {
 	return ms.ents[0].Index + uint64(len(ms.ents)) - 1
 }
 
+// LastTerm returns the term of the last entry in the log, or the term of
+// the snapshot if the log holds no entries beyond it. It is equivalent to
+// Term(LastIndex()) but takes the lock only once.
+func (ms *MemoryStorage) LastTerm() (uint64, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	return ms.ents[len(ms.ents)-1].Term, nil
+}
+
 // FirstIndex implements the Storage interface.
 func (ms *MemoryStorage) FirstIndex() (uint64, error) {
 	ms.Lock()
@@ -167,6 +328,49 @@ func (ms *MemoryStorage) Snapshot() (pb.Snapshot, error) {
 	return ms.snapshot, nil
 }
 
+// DebugString returns a compact multi-line rendering of the snapshot
+// metadata, the hard state and the range of entries held by the storage.
+// It is intended for debugging only.
+func (ms *MemoryStorage) DebugString() string {
+	ms.Lock()
+	defer ms.Unlock()
+	var buf bytes.Buffer
+	md := ms.snapshot.Metadata
+	fmt.Fprintf(&buf, "snapshot: index=%d term=%d voters=%v learners=%v len(data)=%d\n",
+		md.Index, md.Term, md.ConfState.Nodes, md.ConfState.Learners, len(ms.snapshot.Data))
+	fmt.Fprintf(&buf, "hardstate: term=%d vote=%d commit=%d\n",
+		ms.hardState.Term, ms.hardState.Vote, ms.hardState.Commit)
+	if len(ms.ents) == 1 {
+		fmt.Fprintf(&buf, "entries: none (offset=%d/%d)\n", ms.ents[0].Term, ms.ents[0].Index)
+	} else {
+		first, last := ms.ents[1], ms.ents[len(ms.ents)-1]
+		fmt.Fprintf(&buf, "entries: [%d/%d, %d/%d] len=%d\n",
+			first.Term, first.Index, last.Term, last.Index, len(ms.ents)-1)
+	}
+	return buf.String()
+}
+
+// SnapshotSized implements the SizedSnapshotStorage interface.
+func (ms *MemoryStorage) SnapshotSized(maxBytes uint64) (pb.Snapshot, bool, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	snap := ms.snapshot
+	if uint64(len(snap.Data)) <= maxBytes {
+		return snap, true, nil
+	}
+	// limit the capacity too, so that appending to the returned data
+	// cannot overwrite the data of the stored snapshot.
+	snap.Data = snap.Data[:maxBytes:maxBytes]
+	return snap, false, nil
+}
+
+// SnapshotSize implements the SnapshotSizeStorage interface.
+func (ms *MemoryStorage) SnapshotSize() (uint64, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	return uint64(len(ms.snapshot.Data)), nil
+}
+
 // ApplySnapshot overwrites the contents of this Storage object with
 // those of the given snapshot.
 func (ms *MemoryStorage) ApplySnapshot(snap pb.Snapshot) error {
@@ -192,22 +396,66 @@ func (ms *MemoryStorage) ApplySnapshot(snap pb.Snapshot) error {
 func (ms *MemoryStorage) CreateSnapshot(i uint64, cs *pb.ConfState, data []byte) (pb.Snapshot, error) {
 	ms.Lock()
 	defer ms.Unlock()
-	if i <= ms.snapshot.Metadata.Index {
-		return pb.Snapshot{}, ErrSnapOutOfDate
+	if err := ms.checkSnapshot(i); err != nil {
+		return pb.Snapshot{}, err
 	}
+	return ms.createSnapshot(i, cs, data), nil
+}
 
-	offset := ms.ents[0].Index
+// checkSnapshot returns ErrSnapOutOfDate if a snapshot can't be created at
+// index i because it isn't newer than the existing one, and panics if i is
+// beyond the last index. The caller must hold ms.Mutex.
+func (ms *MemoryStorage) checkSnapshot(i uint64) error {
+	if i <= ms.snapshot.Metadata.Index {
+		return ErrSnapOutOfDate
+	}
 	if i > ms.lastIndex() {
 		raftLogger.Panicf("snapshot %d is out of bound lastindex(%d)", i, ms.lastIndex())
 	}
+	return nil
+}
 
+// createSnapshot replaces the snapshot with one at index i, which must have
+// passed checkSnapshot. The caller must hold ms.Mutex.
+func (ms *MemoryStorage) createSnapshot(i uint64, cs *pb.ConfState, data []byte) pb.Snapshot {
+	offset := ms.ents[0].Index
 	ms.snapshot.Metadata.Index = i
 	ms.snapshot.Metadata.Term = ms.ents[i-offset].Term
 	if cs != nil {
 		ms.snapshot.Metadata.ConfState = *cs
 	}
 	ms.snapshot.Data = data
-	return ms.snapshot, nil
+	return ms.snapshot
+}
+
+// PendingEntries returns the number of entries after appliedIndex, that is,
+// those which are stored but have not been applied yet. It returns
+// ErrCompacted if some of those entries have already been compacted away,
+// i.e. if appliedIndex+1 precedes FirstIndex().
+func (ms *MemoryStorage) PendingEntries(appliedIndex uint64) (int, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	if appliedIndex < ms.ents[0].Index {
+		return 0, ErrCompacted
+	}
+	last := ms.lastIndex()
+	if appliedIndex >= last {
+		return 0, nil
+	}
+	return int(last - appliedIndex), nil
+}
+
+// ShouldSnapshot reports whether the applied index has moved at least
+// threshold entries past FirstIndex(), which is a common heuristic for
+// deciding when to create a snapshot and compact the log.
+func (ms *MemoryStorage) ShouldSnapshot(appliedIndex, threshold uint64) bool {
+	ms.Lock()
+	defer ms.Unlock()
+	first := ms.firstIndex()
+	if appliedIndex < first {
+		return false
+	}
+	return appliedIndex-first >= threshold
 }
 
 // Compact discards all log entries prior to compactIndex.
@@ -216,20 +464,110 @@ func (ms *MemoryStorage) CreateSnapshot(i uint64, cs *pb.ConfState, data []byte)
 func (ms *MemoryStorage) Compact(compactIndex uint64) error {
 	ms.Lock()
 	defer ms.Unlock()
-	offset := ms.ents[0].Index
-	if compactIndex <= offset {
+	if err := ms.checkCompact(compactIndex); err != nil {
+		return err
+	}
+	ms.compact(compactIndex)
+	return nil
+}
+
+// SnapshotAndCompact creates a snapshot at index i, like CreateSnapshot,
+// and discards all log entries prior to i, like Compact, as a single atomic
+// operation. All bounds are checked before anything is modified, so on error
+// both the snapshot and the log are left untouched.
+func (ms *MemoryStorage) SnapshotAndCompact(i uint64, cs *pb.ConfState, data []byte) (pb.Snapshot, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	if err := ms.checkSnapshot(i); err != nil {
+		return pb.Snapshot{}, err
+	}
+	if err := ms.checkCompact(i); err != nil {
+		return pb.Snapshot{}, err
+	}
+	snap := ms.createSnapshot(i, cs, data)
+	ms.compact(i)
+	return snap, nil
+}
+
+// checkCompact returns ErrCompacted if compactIndex has already been
+// compacted, and panics if it is beyond the last index. The caller must hold
+// ms.Mutex.
+func (ms *MemoryStorage) checkCompact(compactIndex uint64) error {
+	if compactIndex <= ms.ents[0].Index {
 		return ErrCompacted
 	}
 	if compactIndex > ms.lastIndex() {
 		raftLogger.Panicf("compact %d is out of bound lastindex(%d)", compactIndex, ms.lastIndex())
 	}
+	return nil
+}
 
-	i := compactIndex - offset
+// compact discards all log entries prior to compactIndex, which must have
+// passed checkCompact. The caller must hold ms.Mutex.
+func (ms *MemoryStorage) compact(compactIndex uint64) {
+	i := compactIndex - ms.ents[0].Index
 	ents := make([]pb.Entry, 1, 1+uint64(len(ms.ents))-i)
 	ents[0].Index = ms.ents[i].Index
 	ents[0].Term = ms.ents[i].Term
 	ents = append(ents, ms.ents[i+1:]...)
 	ms.ents = ents
+}
+
+// TruncateSuffix discards all log entries at and after index. It returns
+// ErrCompacted if index does not lie beyond FirstIndex(), ErrTruncateCommitted
+// if index is at or below the Commit of the current HardState, and
+// ErrUnavailable if index is greater than LastIndex()+1. Truncating at
+// LastIndex()+1 is a no-op.
+func (ms *MemoryStorage) TruncateSuffix(index uint64) error {
+	ms.Lock()
+	defer ms.Unlock()
+	if index <= ms.firstIndex() {
+		return ErrCompacted
+	}
+	if index <= ms.hardState.Commit {
+		return ErrTruncateCommitted
+	}
+	if index > ms.lastIndex()+1 {
+		return ErrUnavailable
+	}
+
+	offset := ms.ents[0].Index
+	// copy the retained prefix since slices handed out by Entries may
+	// alias ms.ents and must not be overwritten by a later Append.
+	ms.ents = append([]pb.Entry{}, ms.ents[:index-offset]...)
+	return nil
+}
+
+// Grow pre-allocates room for n additional entries so that a subsequent
+// bulk Append extending the log does not need to reallocate. If n is
+// negative, Grow panics.
+func (ms *MemoryStorage) Grow(n int) {
+	if n < 0 {
+		raftLogger.Panicf("cannot grow storage by negative count %d", n)
+	}
+	ms.Lock()
+	defer ms.Unlock()
+	if cap(ms.ents)-len(ms.ents) >= n {
+		return
+	}
+	ents := make([]pb.Entry, len(ms.ents), len(ms.ents)+n)
+	copy(ents, ms.ents)
+	ms.ents = ents
+}
+
+// VerifyTermMonotonic checks that the terms of the stored entries never
+// decrease, starting from the term of the last snapshot, and returns an
+// error naming the first index at which they do.
+func (ms *MemoryStorage) VerifyTermMonotonic() error {
+	ms.Lock()
+	defer ms.Unlock()
+	for i := 1; i < len(ms.ents); i++ {
+		prev, cur := ms.ents[i-1], ms.ents[i]
+		if cur.Term < prev.Term {
+			return fmt.Errorf("term regression at index %d: term %d is lower than term %d at index %d",
+				cur.Index, cur.Term, prev.Term, prev.Index)
+		}
+	}
 	return nil
 }
 
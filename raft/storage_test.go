@@ -283,3 +283,559 @@ func TestStorageApplySnapshot(t *testing.T) {
 		t.Errorf("#%d: err = %v, want %v", i, err, ErrSnapOutOfDate)
 	}
 }
+
+func TestStorageTruncateSuffix(t *testing.T) {
+	ents := []pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}}
+	tests := []struct {
+		i      uint64
+		commit uint64
+
+		werr     error
+		wentries []pb.Entry
+	}{
+		{3, 0, ErrCompacted, []pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}}},
+		{4, 0, ErrCompacted, []pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}}},
+		{5, 0, nil, []pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}}},
+		{5, 4, nil, []pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}}},
+		// committed entries must not be discarded
+		{5, 5, ErrTruncateCommitted, []pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}}},
+		{6, 0, nil, []pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}}},
+		{6, 5, nil, []pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}}},
+		{7, 0, ErrUnavailable, []pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}}},
+	}
+
+	for i, tt := range tests {
+		s := &MemoryStorage{ents: ents, hardState: pb.HardState{Commit: tt.commit}}
+		err := s.TruncateSuffix(tt.i)
+		if err != tt.werr {
+			t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+		}
+		if !reflect.DeepEqual(s.ents, tt.wentries) {
+			t.Errorf("#%d: entries = %v, want %v", i, s.ents, tt.wentries)
+		}
+	}
+}
+
+func TestStorageGrow(t *testing.T) {
+	s := NewMemoryStorage()
+	s.Grow(100)
+	if c := cap(s.ents); c < 101 {
+		t.Fatalf("cap = %d, want at least %d", c, 101)
+	}
+
+	ents := make([]pb.Entry, 100)
+	for i := range ents {
+		ents[i] = pb.Entry{Index: uint64(i + 1), Term: 1}
+	}
+	before := &s.ents[0]
+	if err := s.Append(ents); err != nil {
+		t.Fatal(err)
+	}
+	if after := &s.ents[0]; after != before {
+		t.Errorf("append after Grow reallocated the entries")
+	}
+	if last, _ := s.LastIndex(); last != 100 {
+		t.Errorf("last = %d, want %d", last, 100)
+	}
+
+	// growing within the spare capacity keeps the backing array.
+	s = NewMemoryStorage()
+	s.Grow(10)
+	before = &s.ents[0]
+	s.Grow(5)
+	if after := &s.ents[0]; after != before {
+		t.Errorf("Grow within capacity reallocated the entries")
+	}
+}
+
+func TestStorageDebugString(t *testing.T) {
+	s := NewMemoryStorage()
+	s.ApplySnapshot(pb.Snapshot{
+		Data:     []byte("data"),
+		Metadata: pb.SnapshotMetadata{Index: 3, Term: 2, ConfState: pb.ConfState{Nodes: []uint64{1, 2, 3}}},
+	})
+	s.SetHardState(pb.HardState{Term: 3, Vote: 1, Commit: 4})
+
+	w := "snapshot: index=3 term=2 voters=[1 2 3] learners=[] len(data)=4\n" +
+		"hardstate: term=3 vote=1 commit=4\n" +
+		"entries: none (offset=2/3)\n"
+	if g := s.DebugString(); g != w {
+		t.Errorf("got:\n%s\nwant:\n%s", g, w)
+	}
+
+	s.Append([]pb.Entry{{Index: 4, Term: 2}, {Index: 5, Term: 3}})
+	w = "snapshot: index=3 term=2 voters=[1 2 3] learners=[] len(data)=4\n" +
+		"hardstate: term=3 vote=1 commit=4\n" +
+		"entries: [2/4, 3/5] len=2\n"
+	if g := s.DebugString(); g != w {
+		t.Errorf("got:\n%s\nwant:\n%s", g, w)
+	}
+}
+
+// snapshotOnlyStorage hides the optional interfaces implemented by the
+// embedded MemoryStorage.
+type snapshotOnlyStorage struct {
+	Storage
+}
+
+func TestSnapshotSized(t *testing.T) {
+	ms := NewMemoryStorage()
+	md := pb.SnapshotMetadata{Index: 4, Term: 4}
+	ms.ApplySnapshot(pb.Snapshot{Data: []byte("data"), Metadata: md})
+
+	tests := []struct {
+		s        Storage
+		maxBytes uint64
+
+		wsnap     pb.Snapshot
+		wcomplete bool
+	}{
+		{ms, 10, pb.Snapshot{Data: []byte("data"), Metadata: md}, true},
+		{ms, 4, pb.Snapshot{Data: []byte("data"), Metadata: md}, true},
+		{ms, 3, pb.Snapshot{Data: []byte("dat"), Metadata: md}, false},
+		// the fallback always returns the full snapshot
+		{snapshotOnlyStorage{ms}, 10, pb.Snapshot{Data: []byte("data"), Metadata: md}, true},
+		{snapshotOnlyStorage{ms}, 3, pb.Snapshot{Data: []byte("data"), Metadata: md}, true},
+	}
+
+	for i, tt := range tests {
+		snap, complete, err := SnapshotSized(tt.s, tt.maxBytes)
+		if err != nil {
+			t.Errorf("#%d: err = %v, want nil", i, err)
+		}
+		if complete != tt.wcomplete {
+			t.Errorf("#%d: complete = %t, want %t", i, complete, tt.wcomplete)
+		}
+		if !reflect.DeepEqual(snap, tt.wsnap) {
+			t.Errorf("#%d: snap = %+v, want %+v", i, snap, tt.wsnap)
+		}
+	}
+
+	// appending to truncated data must not modify the stored snapshot.
+	snap, _, _ := ms.SnapshotSized(2)
+	snap.Data = append(snap.Data, 'X')
+	if g, _ := ms.Snapshot(); string(g.Data) != "data" {
+		t.Errorf("snapshot data = %q, want %q", g.Data, "data")
+	}
+}
+
+func TestStorageShouldSnapshot(t *testing.T) {
+	ents := []pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}, {Index: 6, Term: 6}}
+	tests := []struct {
+		applied, threshold uint64
+
+		w bool
+	}{
+		// applied precedes the first index
+		{3, 0, false},
+		{3, 1, false},
+		{4, 0, true},
+		{4, 1, false},
+		{5, 2, false},
+		{6, 2, true},
+		{6, 3, false},
+	}
+
+	for i, tt := range tests {
+		s := &MemoryStorage{ents: ents}
+		if g := s.ShouldSnapshot(tt.applied, tt.threshold); g != tt.w {
+			t.Errorf("#%d: should snapshot = %t, want %t", i, g, tt.w)
+		}
+	}
+}
+
+// unlimitedStorage is a Storage that ignores the maxSize argument of Entries.
+type unlimitedStorage struct {
+	*MemoryStorage
+}
+
+func (s unlimitedStorage) Entries(lo, hi, _ uint64) ([]pb.Entry, error) {
+	return s.MemoryStorage.Entries(lo, hi, math.MaxUint64)
+}
+
+func TestMaxSizeEnforcingStorageEntries(t *testing.T) {
+	ents := []pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}, {Index: 6, Term: 6}}
+	tests := []struct {
+		lo, hi, maxsize uint64
+
+		werr     error
+		wentries []pb.Entry
+	}{
+		{3, 4, math.MaxUint64, ErrCompacted, nil},
+		{4, 7, math.MaxUint64, nil, []pb.Entry{{Index: 4, Term: 4}, {Index: 5, Term: 5}, {Index: 6, Term: 6}}},
+		// even if maxsize is zero, the first entry should be returned
+		{4, 7, 0, nil, []pb.Entry{{Index: 4, Term: 4}}},
+		// limit to 2
+		{4, 7, uint64(ents[1].Size() + ents[2].Size()), nil, []pb.Entry{{Index: 4, Term: 4}, {Index: 5, Term: 5}}},
+		{4, 7, uint64(ents[1].Size() + ents[2].Size() + ents[3].Size() - 1), nil, []pb.Entry{{Index: 4, Term: 4}, {Index: 5, Term: 5}}},
+	}
+
+	for i, tt := range tests {
+		s := NewMaxSizeEnforcingStorage(unlimitedStorage{&MemoryStorage{ents: ents}})
+		entries, err := s.Entries(tt.lo, tt.hi, tt.maxsize)
+		if err != tt.werr {
+			t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+		}
+		if !reflect.DeepEqual(entries, tt.wentries) {
+			t.Errorf("#%d: entries = %v, want %v", i, entries, tt.wentries)
+		}
+	}
+}
+
+func TestMaxSizeEnforcingStorageSnapshotSized(t *testing.T) {
+	ms := NewMemoryStorage()
+	ms.ApplySnapshot(pb.Snapshot{Data: []byte("data"), Metadata: pb.SnapshotMetadata{Index: 4, Term: 4}})
+
+	tests := []struct {
+		s Storage
+
+		wdata     string
+		wcomplete bool
+	}{
+		// the truncating SnapshotSized of the wrapped storage is used
+		{ms, "dat", false},
+		// the fallback is used if the wrapped storage has none
+		{snapshotOnlyStorage{ms}, "data", true},
+	}
+
+	for i, tt := range tests {
+		snap, complete, err := SnapshotSized(NewMaxSizeEnforcingStorage(tt.s), 3)
+		if err != nil {
+			t.Errorf("#%d: err = %v, want nil", i, err)
+		}
+		if string(snap.Data) != tt.wdata {
+			t.Errorf("#%d: data = %q, want %q", i, snap.Data, tt.wdata)
+		}
+		if complete != tt.wcomplete {
+			t.Errorf("#%d: complete = %t, want %t", i, complete, tt.wcomplete)
+		}
+	}
+}
+
+func TestMaxSizeEnforcingStorageSnapshotSize(t *testing.T) {
+	cs := &countingStorage{MemoryStorage: NewMemoryStorage()}
+	cs.ApplySnapshot(pb.Snapshot{Data: []byte("data"), Metadata: pb.SnapshotMetadata{Index: 4, Term: 4}})
+
+	tests := []struct {
+		s Storage
+
+		wsnapshots int
+	}{
+		// the cheap path of the wrapped storage is used
+		{cs, 0},
+		// the fallback is used if the wrapped storage has no cheap path
+		{snapshotOnlyStorage{cs}, 1},
+	}
+
+	for i, tt := range tests {
+		cs.snapshots = 0
+		size, err := SnapshotSize(NewMaxSizeEnforcingStorage(tt.s))
+		if err != nil {
+			t.Errorf("#%d: err = %v, want nil", i, err)
+		}
+		if size != 4 {
+			t.Errorf("#%d: size = %d, want %d", i, size, 4)
+		}
+		if cs.snapshots != tt.wsnapshots {
+			t.Errorf("#%d: snapshots = %d, want %d", i, cs.snapshots, tt.wsnapshots)
+		}
+	}
+}
+
+func TestStoragePendingEntries(t *testing.T) {
+	ents := []pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}, {Index: 6, Term: 6}}
+	tests := []struct {
+		applied uint64
+
+		werr error
+		wn   int
+	}{
+		{2, ErrCompacted, 0},
+		{3, nil, 3},
+		{4, nil, 2},
+		{6, nil, 0},
+		{7, nil, 0},
+	}
+
+	for i, tt := range tests {
+		s := &MemoryStorage{ents: ents}
+		n, err := s.PendingEntries(tt.applied)
+		if err != tt.werr {
+			t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+		}
+		if n != tt.wn {
+			t.Errorf("#%d: pending = %d, want %d", i, n, tt.wn)
+		}
+	}
+}
+
+func TestStorageSnapshotAndCompact(t *testing.T) {
+	ents := []pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}}
+	cs := &pb.ConfState{Nodes: []uint64{1, 2, 3}}
+	data := []byte("data")
+	oldSnap := pb.Snapshot{Metadata: pb.SnapshotMetadata{Index: 2, Term: 2}}
+
+	tests := []struct {
+		snap pb.Snapshot
+		i    uint64
+
+		werr     error
+		wpanic   bool
+		wsnap    pb.Snapshot
+		wentries []pb.Entry
+	}{
+		// older than the existing snapshot
+		{pb.Snapshot{Metadata: pb.SnapshotMetadata{Index: 4, Term: 4}}, 4, ErrSnapOutOfDate, false,
+			pb.Snapshot{Metadata: pb.SnapshotMetadata{Index: 4, Term: 4}}, ents},
+		// already compacted, though newer than the snapshot
+		{oldSnap, 3, ErrCompacted, false, oldSnap, ents},
+		// out of bound
+		{oldSnap, 6, nil, true, oldSnap, ents},
+		{oldSnap, 4, nil, false,
+			pb.Snapshot{Data: data, Metadata: pb.SnapshotMetadata{Index: 4, Term: 4, ConfState: *cs}},
+			[]pb.Entry{{Index: 4, Term: 4}, {Index: 5, Term: 5}}},
+		{oldSnap, 5, nil, false,
+			pb.Snapshot{Data: data, Metadata: pb.SnapshotMetadata{Index: 5, Term: 5, ConfState: *cs}},
+			[]pb.Entry{{Index: 5, Term: 5}}},
+	}
+
+	for i, tt := range tests {
+		s := &MemoryStorage{ents: ents, snapshot: tt.snap}
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					if !tt.wpanic {
+						t.Errorf("#%d: panic = %v, want %v", i, true, tt.wpanic)
+					}
+				}
+			}()
+
+			snap, err := s.SnapshotAndCompact(tt.i, cs, data)
+			if tt.wpanic {
+				t.Errorf("#%d: panic = %v, want %v", i, false, tt.wpanic)
+			}
+			if err != tt.werr {
+				t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+			}
+			if err == nil && !reflect.DeepEqual(snap, tt.wsnap) {
+				t.Errorf("#%d: snap = %+v, want %+v", i, snap, tt.wsnap)
+			}
+		}()
+		if !reflect.DeepEqual(s.snapshot, tt.wsnap) {
+			t.Errorf("#%d: storage snap = %+v, want %+v", i, s.snapshot, tt.wsnap)
+		}
+		if !reflect.DeepEqual(s.ents, tt.wentries) {
+			t.Errorf("#%d: entries = %v, want %v", i, s.ents, tt.wentries)
+		}
+	}
+}
+
+func TestStorageEntry(t *testing.T) {
+	ents := []pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5, Data: []byte("foo")}}
+	tests := []struct {
+		i uint64
+
+		werr   error
+		wentry pb.Entry
+	}{
+		{2, ErrCompacted, pb.Entry{}},
+		{3, ErrCompacted, pb.Entry{}},
+		{4, nil, pb.Entry{Index: 4, Term: 4}},
+		{5, nil, pb.Entry{Index: 5, Term: 5, Data: []byte("foo")}},
+		{6, ErrUnavailable, pb.Entry{}},
+	}
+
+	for i, tt := range tests {
+		s := &MemoryStorage{ents: ents}
+		e, err := s.Entry(tt.i)
+		if err != tt.werr {
+			t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+		}
+		if !reflect.DeepEqual(e, tt.wentry) {
+			t.Errorf("#%d: entry = %v, want %v", i, e, tt.wentry)
+		}
+	}
+}
+
+func TestStorageLastTerm(t *testing.T) {
+	ents := []pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}}
+	s := &MemoryStorage{ents: ents}
+
+	term, err := s.LastTerm()
+	if err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+	if term != 5 {
+		t.Errorf("term = %d, want %d", term, 5)
+	}
+
+	// only the snapshot is left.
+	s = NewMemoryStorage()
+	s.ApplySnapshot(pb.Snapshot{Metadata: pb.SnapshotMetadata{Index: 10, Term: 7}})
+	term, err = s.LastTerm()
+	if err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+	if term != 7 {
+		t.Errorf("term = %d, want %d", term, 7)
+	}
+}
+
+func TestStorageConfChangeIndexes(t *testing.T) {
+	tests := []struct {
+		ents []pb.Entry
+
+		widxs []uint64
+	}{
+		{[]pb.Entry{{Index: 3, Term: 3}}, nil},
+		{[]pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}}, nil},
+		// the dummy entry is never reported
+		{[]pb.Entry{{Index: 3, Term: 3, Type: pb.EntryConfChange}, {Index: 4, Term: 4}}, nil},
+		{
+			[]pb.Entry{
+				{Index: 3, Term: 3},
+				{Index: 4, Term: 4, Type: pb.EntryConfChange},
+				{Index: 5, Term: 4},
+				{Index: 6, Term: 4},
+				{Index: 7, Term: 5, Type: pb.EntryConfChange},
+				{Index: 8, Term: 5, Type: pb.EntryConfChange},
+				{Index: 9, Term: 5},
+			},
+			[]uint64{4, 7, 8},
+		},
+	}
+
+	for i, tt := range tests {
+		s := &MemoryStorage{ents: tt.ents}
+		idxs, err := s.ConfChangeIndexes()
+		if err != nil {
+			t.Errorf("#%d: err = %v, want nil", i, err)
+		}
+		if !reflect.DeepEqual(idxs, tt.widxs) {
+			t.Errorf("#%d: indexes = %v, want %v", i, idxs, tt.widxs)
+		}
+	}
+}
+
+func TestStorageVerifyTermMonotonic(t *testing.T) {
+	tests := []struct {
+		ents []pb.Entry
+
+		werr string
+	}{
+		{[]pb.Entry{{Index: 3, Term: 3}}, ""},
+		{[]pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 3}, {Index: 5, Term: 5}}, ""},
+		// the first entry may not precede the term of the snapshot
+		{
+			[]pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 2}},
+			"term regression at index 4: term 2 is lower than term 3 at index 3",
+		},
+		{
+			[]pb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}, {Index: 6, Term: 4}, {Index: 7, Term: 3}},
+			"term regression at index 6: term 4 is lower than term 5 at index 5",
+		},
+	}
+
+	for i, tt := range tests {
+		s := &MemoryStorage{ents: tt.ents}
+		err := s.VerifyTermMonotonic()
+		var g string
+		if err != nil {
+			g = err.Error()
+		}
+		if g != tt.werr {
+			t.Errorf("#%d: err = %q, want %q", i, g, tt.werr)
+		}
+	}
+}
+
+func TestStorageReset(t *testing.T) {
+	s := NewMemoryStorage()
+	cs := pb.ConfState{Nodes: []uint64{1, 2, 3}}
+	s.ApplySnapshot(pb.Snapshot{Data: []byte("data"), Metadata: pb.SnapshotMetadata{Index: 4, Term: 4, ConfState: cs}})
+	s.SetHardState(pb.HardState{Term: 5, Vote: 1, Commit: 6})
+	s.Append([]pb.Entry{{Index: 5, Term: 5}, {Index: 6, Term: 5}})
+
+	s.Reset()
+
+	w := NewMemoryStorage()
+	hs, cs, err := s.InitialState()
+	whs, wcs, _ := w.InitialState()
+	if err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+	if !reflect.DeepEqual(hs, whs) {
+		t.Errorf("hard state = %+v, want %+v", hs, whs)
+	}
+	if !reflect.DeepEqual(cs, wcs) {
+		t.Errorf("conf state = %+v, want %+v", cs, wcs)
+	}
+	if !reflect.DeepEqual(s, w) {
+		t.Errorf("storage = %+v, want %+v", s, w)
+	}
+	if err := s.Append([]pb.Entry{{Index: 1, Term: 1}}); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+}
+
+// countingStorage counts the calls to Snapshot, to verify that the cheap
+// SnapshotSizeStorage path of the embedded MemoryStorage is taken.
+type countingStorage struct {
+	*MemoryStorage
+	snapshots int
+}
+
+func (s *countingStorage) Snapshot() (pb.Snapshot, error) {
+	s.snapshots++
+	return s.MemoryStorage.Snapshot()
+}
+
+func TestSnapshotSize(t *testing.T) {
+	ms := NewMemoryStorage()
+	ms.ApplySnapshot(pb.Snapshot{Data: []byte("data"), Metadata: pb.SnapshotMetadata{Index: 4, Term: 4}})
+
+	tests := []struct {
+		s Storage
+
+		wnative bool
+	}{
+		{ms, true},
+		{snapshotOnlyStorage{ms}, false},
+	}
+
+	for i, tt := range tests {
+		if _, ok := tt.s.(SnapshotSizeStorage); ok != tt.wnative {
+			t.Errorf("#%d: implements SnapshotSizeStorage = %t, want %t", i, ok, tt.wnative)
+		}
+		size, err := SnapshotSize(tt.s)
+		if err != nil {
+			t.Errorf("#%d: err = %v, want nil", i, err)
+		}
+		if size != 4 {
+			t.Errorf("#%d: size = %d, want %d", i, size, 4)
+		}
+	}
+}
+
+func TestAppliedTracker(t *testing.T) {
+	var tracker AppliedTracker
+
+	tests := []struct {
+		applied uint64
+
+		wapplied uint64
+	}{
+		{0, 0},
+		{4, 4},
+		{5, 5},
+		// regressions are ignored
+		{3, 5},
+		{5, 5},
+	}
+	for i, tt := range tests {
+		tracker.SetApplied(tt.applied)
+		if g := tracker.Applied(); g != tt.wapplied {
+			t.Errorf("#%d: applied = %d, want %d", i, g, tt.wapplied)
+		}
+	}
+}